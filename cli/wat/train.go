@@ -8,8 +8,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"regexp"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,24 +18,57 @@ import (
 
 	isatty "github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
+
+	"github.com/windmilleng/wat/corpus"
+	"github.com/windmilleng/wat/coverage"
+	"github.com/windmilleng/wat/mutator"
 )
 
+// The directory (relative to the workspace root) where wat keeps its own
+// metadata, including the fuzz corpus. WalkRoot skips dot-directories, so
+// this never gets fuzzed along with the rest of the project.
+const watMetaDirName = ".wat"
+
+// corpusDirName is the subdirectory of watMetaDirName holding saved fuzz
+// inputs; see the corpus package.
+const corpusDirName = "corpus"
+
+func corpusRoot(ws WatWorkspace) string {
+	return filepath.Join(ws.Root(), watMetaDirName, corpusDirName)
+}
+
 const trainRecencyCutoff = time.Hour
 const trainTTL = 48 * time.Hour
 
-// Only fuzz files that match this suffix.
-// TODO(nick): Will we need to make this configurable?
-var fuzzSuffixes = []string{
-	// TODO(nick): Right now, we add comments to the file that
-	// will only work in JS and Go. If we add other languages, we will
-	// need to make the fuzz step more configurable.
-	".go",
-	".js",
+// The value of the --train-limit flag, before it's been parsed into a
+// trainLimit. Empty means "no limit beyond CmdTimeout".
+var trainLimitFlag string
+
+// The value of the --train-parallelism flag. 0 means "default to
+// GOMAXPROCS", see trainParallelism.
+var trainParallelismFlag int
+
+// Whether --seed-from-corpus was passed: replay every entry in the corpus
+// once, as its own LogSourceCorpus group, before fuzzing at random.
+var trainSeedFromCorpusFlag bool
+
+// How long minimizeFailure may spend shrinking a single failing mutation.
+var trainMinimizeTimeFlag time.Duration
+
+func init() {
+	trainCmd.Flags().StringVar(&trainLimitFlag, "train-limit", "",
+		"Limit training to either a duration (e.g. \"30s\") or a fuzz "+
+			"iteration count (e.g. \"500x\"), which bounds training "+
+			"independently of machine speed; the mutations tried still "+
+			"differ from run to run")
+	trainCmd.Flags().IntVar(&trainParallelismFlag, "train-parallelism", 0,
+		"Number of fuzz workers to run in parallel (default: GOMAXPROCS)")
+	trainCmd.Flags().BoolVar(&trainSeedFromCorpusFlag, "seed-from-corpus", false,
+		"Replay every saved corpus entry once before fuzzing at random")
+	trainCmd.Flags().DurationVar(&trainMinimizeTimeFlag, "minimize-time", 30*time.Second,
+		"Max wall-time to spend shrinking a failing fuzz mutation before recording it")
 }
 
-var matchFalse = regexp.MustCompile("\\bfalse\\b")
-var matchZero = regexp.MustCompile("\\b0\\b")
-
 var trainCmd = &cobra.Command{
 	Use:   "train",
 	Short: "Train a model to make decisions on what to test",
@@ -50,12 +84,17 @@ func train(cmd *cobra.Command, args []string) {
 		ws.Fatal("GetWatWorkspace", err)
 	}
 
+	limit, err := parseTrainLimit(trainLimitFlag)
+	if err != nil {
+		ws.Fatal("train-limit", err)
+	}
+
 	cmds, err := populateAt(ctx, ws)
 	if err != nil {
 		ws.Fatal("List", err)
 	}
 
-	logs, err := Train(ctx, ws, cmds, 0 /* always fresh */)
+	logs, err := Train(ctx, ws, cmds, 0 /* always fresh */, limit)
 	if err != nil {
 		ws.Fatal("Train", err)
 	}
@@ -72,7 +111,7 @@ func train(cmd *cobra.Command, args []string) {
 //
 // If sufficiently fresh training data lives on disk, return that data.
 // Otherwise, generate new training data and write it to disk.
-func Train(ctx context.Context, ws WatWorkspace, cmds []WatCommand, ttl time.Duration) ([]CommandLogGroup, error) {
+func Train(ctx context.Context, ws WatWorkspace, cmds []WatCommand, ttl time.Duration, limit trainLimit) ([]CommandLogGroup, error) {
 	if ttl > 0 {
 		info, err := ws.Stat(fnameCmdLog)
 		if err != nil && !os.IsNotExist(err) {
@@ -92,7 +131,7 @@ func Train(ctx context.Context, ws WatWorkspace, cmds []WatCommand, ttl time.Dur
 		}
 	}
 
-	result, err := trainAt(ctx, ws, cmds)
+	result, err := trainAt(ctx, ws, cmds, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -104,6 +143,40 @@ func Train(ctx context.Context, ws WatWorkspace, cmds []WatCommand, ttl time.Dur
 	return result, nil
 }
 
+// A limit on how much work a training run is allowed to do, in addition to
+// the ambient CmdTimeout. Exactly one of duration/count is set; the zero
+// value means "no additional limit".
+type trainLimit struct {
+	duration time.Duration
+	count    uint64
+}
+
+// Parses the --train-limit flag.
+//
+// Mirrors the way `go test -fuzztime` accepts either a plain duration
+// (e.g. "30s") or an "Nx" count of iterations (e.g. "500x"): if the value
+// ends in "x", it's a fuzz iteration count; otherwise it's a time.Duration.
+// An empty string means "no limit".
+func parseTrainLimit(s string) (trainLimit, error) {
+	if s == "" {
+		return trainLimit{}, nil
+	}
+
+	if strings.HasSuffix(s, "x") {
+		n, err := strconv.ParseUint(strings.TrimSuffix(s, "x"), 10, 64)
+		if err != nil {
+			return trainLimit{}, fmt.Errorf("invalid --train-limit %q: %v", s, err)
+		}
+		return trainLimit{count: n}, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return trainLimit{}, fmt.Errorf("invalid --train-limit %q: %v", s, err)
+	}
+	return trainLimit{duration: d}, nil
+}
+
 type LogSource int
 
 const (
@@ -123,12 +196,20 @@ const (
 	// Logs generated when the trainer runs the commands
 	// in the workspace for the first time.
 	LogSourceTrainInit
+
+	// A replay of a previously-saved corpus entry, from --seed-from-corpus.
+	LogSourceCorpus
 )
 
 // All the commands that ran at a particular state of the workspace, grouped together.
 type CommandLogGroup struct {
 	Logs    []CommandLog
 	Context LogContext
+
+	// True if any command in this group exercised a code path that no
+	// earlier fuzz iteration in this training run had covered. Only ever
+	// set for LogSourceFuzz groups; see the coverage package.
+	NewCoverage bool
 }
 
 func newCommandLogGroup(ctx LogContext) *CommandLogGroup {
@@ -146,6 +227,11 @@ type LogContext struct {
 
 	StartTime time.Time
 	Source    LogSource
+
+	// The 1-indexed fuzz iteration number within this training run.
+	// Only set on LogSourceFuzz entries; lets --train-limit=Nx reproduce
+	// the same amount of work on every machine.
+	Iteration int
 }
 
 type CommandLog struct {
@@ -154,9 +240,19 @@ type CommandLog struct {
 
 	Success  bool
 	Duration time.Duration
+
+	// How many previously-uncovered basic blocks this command's run
+	// exercised, for `go test` commands profiled by the coverage package.
+	// Zero for non-test commands and for blocks we'd already seen.
+	CoverageDelta int
+
+	// How many bytes smaller than the original mutation the minimize step
+	// was able to shrink this command's failing input to. Zero if the
+	// command didn't fail, or minimize couldn't shrink it at all.
+	MinimizedBytes int
 }
 
-func trainAt(ctx context.Context, ws WatWorkspace, cmds []WatCommand) ([]CommandLogGroup, error) {
+func trainAt(ctx context.Context, ws WatWorkspace, cmds []WatCommand, limit trainLimit) ([]CommandLogGroup, error) {
 	if isatty.IsTerminal(os.Stdout.Fd()) {
 		fmt.Fprintln(os.Stderr, "Beginning training...type <Enter> or <Esc> to interrupt")
 
@@ -170,6 +266,15 @@ func trainAt(ctx context.Context, ws WatWorkspace, cmds []WatCommand) ([]Command
 		}()
 	}
 
+	// A bounded training run: --train-limit=30s further tightens the
+	// deadline. --train-limit=500x is enforced by the fuzz coordinator
+	// below, since the count has to be tallied across all its workers.
+	if limit.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limit.duration)
+		defer cancel()
+	}
+
 	files, err := ws.WalkRoot()
 	if err != nil {
 		return nil, err
@@ -191,31 +296,46 @@ func trainAt(ctx context.Context, ws WatWorkspace, cmds []WatCommand) ([]Command
 		result = append(result, g)
 	}
 
-	// Fuzz each file and run all commands. This may take a long time. We expect
-	// the user to cancel or time to run out before we finish, so we fuzz the files
-	// in order of recent edits, and handle timeout/cancel gracefully.
-	for _, f := range files {
-		if ctx.Err() != nil {
-			break
-		}
-
-		if !shouldFuzzFile(f.name) {
-			continue
-		}
+	corpusDir := corpusRoot(ws)
 
-		g, err := fuzzAndRun(ctx, cmds, ws.Root(), f.name)
+	if trainSeedFromCorpusFlag {
+		corpusResults, err := replayCorpus(ctx, cmds, ws.Root(), corpusDir)
 		if err != nil {
 			return nil, err
 		}
+		result = append(result, corpusResults...)
+	}
 
-		if len(g.Logs) != 0 {
-			result = append(result, g)
+	// Fuzz files and run all commands against each mutation. This may take a
+	// long time, so a pool of workers (sized by --train-parallelism) does
+	// the fuzzing in parallel, and we expect the user to cancel or time to
+	// run out before we finish; the coordinator handles timeout/cancel
+	// gracefully and reports back whatever finished.
+	var fuzzFiles []string
+	for _, f := range files {
+		if shouldFuzzFile(f.name) {
+			fuzzFiles = append(fuzzFiles, f.name)
 		}
 	}
 
+	fuzzResults, err := runFuzzCoordinator(ctx, cmds, ws.Root(), fuzzFiles, corpusDir, limit, trainParallelism())
+	if err != nil {
+		return nil, err
+	}
+	result = append(result, fuzzResults...)
+
 	return result, nil
 }
 
+// trainParallelism resolves the --train-parallelism flag, defaulting to
+// GOMAXPROCS when unset so `wat train` saturates the machine it's run on.
+func trainParallelism() int {
+	if trainParallelismFlag > 0 {
+		return trainParallelismFlag
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
 // Create an "init" group that runs all the commands in the current workspace.
 func runInitGroup(ctx context.Context, cmds []WatCommand, root string, recentEdit string) (CommandLogGroup, error) {
 	fmt.Fprintln(os.Stderr, "Running all tests in the current workspace")
@@ -227,82 +347,215 @@ func runInitGroup(ctx context.Context, cmds []WatCommand, root string, recentEdi
 }
 
 func runCmdsWithProgress(ctx context.Context, cmds []WatCommand, root string, logCtx LogContext) (CommandLogGroup, error) {
-	g := CommandLogGroup{
-		Context: logCtx,
-	}
 	bar := pb.New(len(cmds))
 	bar.Output = os.Stderr
 	bar.Start()
 	defer bar.FinishPrint("")
 
+	return runCmds(ctx, cmds, root, logCtx, bar, nil)
+}
+
+// runCmds runs cmds against root and returns the resulting log group. If bar
+// is non-nil, it's advanced after each command; pass nil when progress is
+// tracked some other way (e.g. a coordinator aggregating many workers onto
+// one bar).
+//
+// If tracker is non-nil, any `go test` command in cmds is instrumented with
+// -coverprofile before it's run, and the resulting profile is merged into
+// tracker as part of that same run, filling in CoverageDelta/NewCoverage.
+// This is deliberately folded into the one invocation of the command rather
+// than profiled by a second run: re-running would double the cost of every
+// test and risk a flaky test disagreeing with itself between the two runs.
+func runCmds(ctx context.Context, cmds []WatCommand, root string, logCtx LogContext, bar *pb.ProgressBar, tracker *coverage.Tracker) (CommandLogGroup, error) {
+	g := CommandLogGroup{
+		Context: logCtx,
+	}
+
 	for i, cmd := range cmds {
-		l, err := runCmdAndLog(ctx, root, cmd, ioutil.Discard, ioutil.Discard)
+		runCmd := cmd
+		var profilePath string
+		cleanup := func() {}
+		if tracker != nil {
+			instrumented, path, cln, ok := coverage.Instrument(cmd.Command)
+			if ok {
+				runCmd.Command = instrumented
+				profilePath = path
+				cleanup = cln
+			}
+		}
+
+		l, err := runCmdAndLog(ctx, root, runCmd, ioutil.Discard, ioutil.Discard)
+		// The log should reflect the command the user configured, not the
+		// -coverprofile flag we spliced in to measure it.
+		l.Command = cmd.Command
 		if err != nil {
+			cleanup()
 			if err == context.DeadlineExceeded || err == context.Canceled {
 				break
 			}
 			return CommandLogGroup{}, err
 		}
+
+		if profilePath != "" {
+			if bitmap, err := coverage.ParseProfile(profilePath); err == nil {
+				delta := tracker.Merge(bitmap)
+				l.CoverageDelta = delta
+				if delta > 0 {
+					g.NewCoverage = true
+				}
+			}
+		}
+		cleanup()
+
 		g.Logs = append(g.Logs, l)
-		bar.Set(i + 1)
+		if bar != nil {
+			bar.Set(i + 1)
+		}
 	}
 
 	return g, nil
 }
 
+// Mutations are byte-level now, so (unlike the old regex fuzzer) we're not
+// restricted to languages we know how to write a trailing comment for.
 func shouldFuzzFile(fileToFuzz string) bool {
-	for _, suffix := range fuzzSuffixes {
-		if strings.HasSuffix(fileToFuzz, suffix) {
-			return true
-		}
-	}
-	return false
+	return true
 }
 
-// A dumb mutation: replace false with true and 0 with 1.
-func fuzz(contents []byte) []byte {
-	contents = matchFalse.ReplaceAll(contents, []byte("true"))
-	contents = matchZero.ReplaceAll(contents, []byte("1"))
-	return contents
+// fuzzMarkers maps a file extension to the line-comment prefix used to
+// annotate a fuzzed file, so a human (or `git diff`) can tell at a glance
+// that a file was machine-mutated. Extensions we don't recognize are fuzzed
+// without an annotation rather than skipped.
+var fuzzMarkers = map[string]string{
+	".go":   "//",
+	".js":   "//",
+	".ts":   "//",
+	".java": "//",
+	".c":    "//",
+	".cc":   "//",
+	".py":   "#",
+	".rb":   "#",
+	".sh":   "#",
+	".yaml": "#",
+	".yml":  "#",
 }
 
-// Make a random edit to a file and run all tests in the workspace.
-func fuzzAndRun(ctx context.Context, cmds []WatCommand, root, fileToFuzz string) (CommandLogGroup, error) {
+func fuzzMarkerComment(fileToFuzz string) []byte {
+	prefix, ok := fuzzMarkers[filepath.Ext(fileToFuzz)]
+	if !ok {
+		return nil
+	}
+	return []byte(fmt.Sprintf("\n%s Modified by WAT fuzzer (https://github.com/windmilleng/wat)", prefix))
+}
+
+// Make a random edit to a file and run all tests in the workspace, then
+// restore the file to its on-disk contents no matter what happened. tracker
+// (if non-nil) is used to detect and record new coverage, and corpusDir (if
+// non-empty) is where interesting mutations get saved; see the coverage and
+// corpus packages.
+//
+// seed, if non-nil, is mutated instead of the file's current on-disk
+// contents; this is what lets the coordinator keep fuzzing forward from a
+// mutation that found new coverage instead of starting over from the
+// pristine file every time. fuzzAndRun's own return value reports the
+// mutation it just tried, for the coordinator to use as the next seed, if
+// it turns out to have found new coverage.
+func fuzzAndRun(ctx context.Context, m *mutator.Mutator, tracker *coverage.Tracker, corpusDir string, cmds []WatCommand, root, fileToFuzz string, seed []byte) (CommandLogGroup, []byte, error) {
 	absPath := filepath.Join(root, fileToFuzz)
-	oldContents, err := ioutil.ReadFile(absPath)
+	diskContents, err := ioutil.ReadFile(absPath)
 	if err != nil {
-		return CommandLogGroup{}, err
+		return CommandLogGroup{}, nil, err
+	}
+
+	parentContents := diskContents
+	if seed != nil {
+		parentContents = seed
 	}
 
-	newContents := fuzz(oldContents)
-	if bytes.Equal(newContents, oldContents) {
+	newContents := m.Mutate(parentContents)
+	if bytes.Equal(newContents, parentContents) {
 		// if fuzzing does nothing, don't bother.
-		return CommandLogGroup{}, nil
+		return CommandLogGroup{}, nil, nil
 	}
 
-	// TODO(nick): right now this only works in JS and Go
-	newContents = append(newContents,
-		[]byte("\n// Modified by WAT fuzzer (https://github.com/windmilleng/wat)")...)
+	if marker := fuzzMarkerComment(fileToFuzz); marker != nil {
+		newContents = append(newContents, marker...)
+	}
 
 	// We know the file exists, so we expect that this file mode will be ignored
 	mode := permFile
 
 	// It's super important that we clean up the file, even if the user
-	// tries to kill the process.
+	// tries to kill the process, and restore it to what's actually on disk
+	// (not parentContents) so the next job this worker picks up - quite
+	// possibly a different file - starts from a pristine workspace.
+	// Coverage measurement and the interesting-mutation check below must
+	// happen before this restores the original file, so they run inline
+	// rather than back in the caller.
 	tearDown := createCleanup(func() {
-		ioutil.WriteFile(absPath, oldContents, mode)
+		ioutil.WriteFile(absPath, diskContents, mode)
 	})
 	defer tearDown()
 
 	err = ioutil.WriteFile(absPath, newContents, mode)
 	if err != nil {
-		return CommandLogGroup{}, err
+		return CommandLogGroup{}, nil, err
 	}
 
-	_, _ = fmt.Fprintf(os.Stderr, "Fuzzing %q and running all tests\n", fileToFuzz)
-	return runCmdsWithProgress(ctx, cmds, root, LogContext{
+	g, err := runCmds(ctx, cmds, root, LogContext{
 		StartTime:   time.Now(),
 		Source:      LogSourceFuzz,
 		RecentEdits: []string{fileToFuzz},
-	})
+	}, nil, tracker)
+	if err != nil {
+		return CommandLogGroup{}, nil, err
+	}
+
+	// If the mutation made a command fail, try to shrink it down to the
+	// smallest edit that still reproduces the failure before recording it,
+	// so whatever ends up in the corpus is something a human can read. The
+	// search rewrites absPath as it goes; the deferred tearDown above puts
+	// the original file back no matter where it leaves things.
+	savedContents := newContents
+	if idx, ok := firstFailingLog(g); ok && idx < len(cmds) {
+		minimized, minimizedBytes := minimizeFailure(ctx, root, absPath, mode, parentContents, newContents, cmds[:idx], cmds[idx], trainMinimizeTimeFlag)
+		if minimizedBytes > 0 {
+			g.Logs[idx].MinimizedBytes = minimizedBytes
+			savedContents = minimized
+		}
+	}
+
+	saveIfInteresting(corpusDir, fileToFuzz, savedContents, parentContents, g)
+
+	var nextSeed []byte
+	if g.NewCoverage {
+		nextSeed = newContents
+	}
+
+	return g, nextSeed, nil
+}
+
+// saveIfInteresting writes contents to the corpus under corpusDir if g shows
+// it was worth keeping: it failed a command, or (once coverage tracking has
+// run) it found new coverage. A blank corpusDir disables the corpus
+// entirely.
+func saveIfInteresting(corpusDir, relPath string, contents, parentContents []byte, g CommandLogGroup) {
+	if corpusDir == "" {
+		return
+	}
+
+	interesting := g.NewCoverage
+	for _, l := range g.Logs {
+		if !l.Success {
+			interesting = true
+			break
+		}
+	}
+	if !interesting {
+		return
+	}
+
+	if _, err := corpus.Save(corpusDir, relPath, contents, parentContents); err != nil {
+		fmt.Fprintf(os.Stderr, "corpus: could not save %q: %v\n", relPath, err)
+	}
 }
@@ -0,0 +1,288 @@
+package wat
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	pb "gopkg.in/cheggaaa/pb.v1"
+
+	"github.com/windmilleng/wat/coverage"
+	"github.com/windmilleng/wat/mutator"
+)
+
+// maxNoCoverageStreak is how many consecutive mutations of a file may fail
+// to find new coverage before we stop re-fuzzing it.
+const maxNoCoverageStreak = 3
+
+// runFuzzCoordinator fuzzes files in parallel across a pool of workers sized
+// by parallelism, aggregating their results onto a single progress bar. Each
+// worker mutates its own scratch copy of the workspace (see copyWorkspace),
+// so two workers fuzzing at once never clobber each other's in-place edits.
+//
+// Mutations that exercise new coverage (tracked by a shared coverage.Tracker)
+// are requeued at the front of the work, carrying that mutation's contents
+// forward as the seed for the next one, so the coordinator keeps mutating
+// from the newly-interesting bytes rather than starting over from the
+// pristine file on disk; a file that stops finding new coverage for
+// maxNoCoverageStreak iterations in a row is dropped from rotation.
+//
+// The coordinator also enforces limit.count: --train-limit=Nx needs a
+// single, consistent view of how many fuzz iterations have completed, so
+// that tally (and the decision to cancel once it's reached) lives here
+// rather than in the workers.
+func runFuzzCoordinator(ctx context.Context, cmds []WatCommand, root string, files []string, corpusDir string, limit trainLimit, parallelism int) ([]CommandLogGroup, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := newFuzzQueue(files)
+	tracker := coverage.NewTracker()
+
+	results := make(chan fuzzResult)
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go fuzzWorker(ctx, &wg, cmds, root, corpusDir, queue, tracker, results)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	fmt.Fprintf(os.Stderr, "Fuzzing %d files across %d workers\n", len(files), parallelism)
+	bar := pb.New(len(files))
+	bar.Output = os.Stderr
+	bar.Start()
+	defer bar.FinishPrint("")
+
+	var out []CommandLogGroup
+	completed := 0
+	iteration := 0
+	noCoverageStreak := map[string]int{}
+	seeds := map[string][]byte{}
+	for r := range results {
+		completed++
+		bar.Set(completed)
+
+		if len(r.group.Logs) != 0 {
+			iteration++
+			r.group.Context.Iteration = iteration
+			out = append(out, r.group)
+		}
+
+		if r.group.NewCoverage {
+			noCoverageStreak[r.file] = 0
+			seeds[r.file] = r.seed
+		} else {
+			noCoverageStreak[r.file]++
+		}
+
+		if noCoverageStreak[r.file] < maxNoCoverageStreak {
+			queue.requeue(fuzzJob{file: r.file, seed: seeds[r.file]})
+		} else {
+			queue.done()
+		}
+
+		if limit.count > 0 && uint64(iteration) >= limit.count {
+			// Stop handing out new jobs, but keep draining `results` so we
+			// still report whatever the in-flight workers finish.
+			queue.close()
+			cancel()
+		}
+	}
+
+	return out, nil
+}
+
+// fuzzJob is one unit of work handed to a fuzz worker: mutate file, seeded
+// from seed if it's non-nil, or from the file's own contents on disk
+// otherwise.
+type fuzzJob struct {
+	file string
+	seed []byte
+}
+
+// fuzzResult is what a worker reports back to the coordinator for a single
+// fuzzed file. seed is the mutated contents that produced the new coverage
+// in group, for the coordinator to carry forward into the next job for this
+// file; it's nil when group didn't find any new coverage.
+type fuzzResult struct {
+	file  string
+	group CommandLogGroup
+	seed  []byte
+}
+
+// fuzzWorker pulls jobs off queue, fuzzes them inside its own scratch
+// workspace, and reports each resulting CommandLogGroup on results. It
+// exits once queue is drained or ctx is cancelled. Coverage tracking and
+// corpus saving happen inside fuzzAndRun itself, before it restores the
+// original file.
+func fuzzWorker(ctx context.Context, wg *sync.WaitGroup, cmds []WatCommand, root, corpusDir string, queue *fuzzQueue, tracker *coverage.Tracker, results chan<- fuzzResult) {
+	defer wg.Done()
+
+	scratchRoot, cleanup, err := copyWorkspace(root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fuzz worker: could not set up scratch workspace: %v\n", err)
+		return
+	}
+	defer cleanup()
+
+	m := mutator.New(uint64(time.Now().UnixNano()))
+
+	for {
+		job, ok := queue.pop(ctx)
+		if !ok {
+			return
+		}
+
+		g, seed, err := fuzzAndRun(ctx, m, tracker, corpusDir, cmds, scratchRoot, job.file, job.seed)
+		if err != nil {
+			if err == context.DeadlineExceeded || err == context.Canceled {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "fuzz worker: %q: %v\n", job.file, err)
+			g, seed = CommandLogGroup{}, nil
+		}
+
+		select {
+		case results <- fuzzResult{file: job.file, group: g, seed: seed}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// fuzzQueue is a goroutine-safe work queue of files to fuzz. It supports
+// pushing a job back to the front of the queue (to re-mutate a file that
+// just found new coverage, carrying forward the seed that found it) and
+// closing once no more work is expected.
+type fuzzQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	items   []fuzzJob
+	closed  bool
+	pending int // items either queued or currently being processed by a worker
+}
+
+func newFuzzQueue(files []string) *fuzzQueue {
+	items := make([]fuzzJob, len(files))
+	for i, f := range files {
+		items[i] = fuzzJob{file: f}
+	}
+	q := &fuzzQueue{
+		items:   items,
+		pending: len(files),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// pop blocks until a job is available, the queue is closed, or ctx is
+// cancelled.
+func (q *fuzzQueue) pop(ctx context.Context) (fuzzJob, bool) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			q.close()
+		case <-done:
+		}
+	}()
+	defer close(done)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.items) == 0 {
+		return fuzzJob{}, false
+	}
+
+	j := q.items[0]
+	q.items = q.items[1:]
+	return j, true
+}
+
+// requeue puts job back at the front of the queue, to be mutated again.
+func (q *fuzzQueue) requeue(job fuzzJob) {
+	q.mu.Lock()
+	q.items = append([]fuzzJob{job}, q.items...)
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// done marks one fewer item as outstanding. Once every originally-queued
+// file (and every requeue of it) has been accounted for, the queue closes
+// itself so workers can exit.
+func (q *fuzzQueue) done() {
+	q.mu.Lock()
+	q.pending--
+	if q.pending <= 0 {
+		q.closed = true
+	}
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+func (q *fuzzQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// copyWorkspace makes a private copy of root under the system temp dir so a
+// fuzz worker can mutate files without racing other workers operating on the
+// same paths. It skips dot-directories (.git and the like) since workers
+// only need the files commands actually read. The returned cleanup removes
+// the copy; callers should defer it.
+func copyWorkspace(root string) (string, func(), error) {
+	scratchRoot, err := ioutil.TempDir("", "wat-train-worker-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(scratchRoot) }
+
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		if info.IsDir() {
+			if strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(scratchRoot, rel), info.Mode())
+		}
+
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(scratchRoot, rel), contents, info.Mode())
+	})
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	return scratchRoot, cleanup, nil
+}
@@ -0,0 +1,77 @@
+package wat
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFuzzQueuePopReturnsEveryFile(t *testing.T) {
+	q := newFuzzQueue([]string{"a.go", "b.go", "c.go"})
+
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		job, ok := q.pop(context.Background())
+		if !ok {
+			t.Fatalf("pop %d: got ok = false, want true", i)
+		}
+		seen[job.file] = true
+		q.done()
+	}
+	for _, f := range []string{"a.go", "b.go", "c.go"} {
+		if !seen[f] {
+			t.Errorf("never popped %q", f)
+		}
+	}
+
+	if _, ok := q.pop(context.Background()); ok {
+		t.Fatal("pop after every file is done: got ok = true, want false (queue should be closed)")
+	}
+}
+
+func TestFuzzQueueRequeueGoesToFront(t *testing.T) {
+	q := newFuzzQueue([]string{"a.go", "b.go"})
+
+	job, ok := q.pop(context.Background())
+	if !ok || job.file != "a.go" {
+		t.Fatalf("first pop = %+v, %v, want a.go, true", job, ok)
+	}
+
+	q.requeue(fuzzJob{file: "a.go", seed: []byte("interesting")})
+
+	job, ok = q.pop(context.Background())
+	if !ok || job.file != "a.go" {
+		t.Fatalf("pop after requeue = %+v, %v, want a.go, true", job, ok)
+	}
+	if string(job.seed) != "interesting" {
+		t.Fatalf("requeued job.seed = %q, want %q", job.seed, "interesting")
+	}
+
+	q.done() // account for the requeued "a.go"
+	job, ok = q.pop(context.Background())
+	if !ok || job.file != "b.go" {
+		t.Fatalf("pop after a.go drained = %+v, %v, want b.go, true", job, ok)
+	}
+}
+
+func TestFuzzQueuePopUnblocksOnContextCancel(t *testing.T) {
+	q := newFuzzQueue(nil)
+	q.pending = 1 // keep the queue open with no items to pop
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, ok := q.pop(ctx); ok {
+			t.Error("pop after ctx cancel: got ok = true, want false")
+		}
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("pop did not return after ctx was cancelled")
+	}
+}
@@ -0,0 +1,66 @@
+package wat
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/windmilleng/wat/corpus"
+)
+
+// replayCorpus runs cmds once against every entry saved in corpusDir, each
+// as its own LogSourceCorpus group. This is what --seed-from-corpus uses to
+// give every training run regression coverage of previously-interesting
+// inputs, the way Go's fuzz cache keeps growing over time.
+func replayCorpus(ctx context.Context, cmds []WatCommand, root, corpusDir string) ([]CommandLogGroup, error) {
+	entries, err := corpus.List(corpusDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Replaying %d corpus entries\n", len(entries))
+
+	var result []CommandLogGroup
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+
+		g, err := replayCorpusEntry(ctx, cmds, root, e)
+		if err != nil {
+			return nil, err
+		}
+		if len(g.Logs) != 0 {
+			result = append(result, g)
+		}
+	}
+	return result, nil
+}
+
+// replayCorpusEntry applies e to its own scratch copy of the workspace (so a
+// replay can't race the fuzz coordinator's workers) and runs cmds against
+// it.
+func replayCorpusEntry(ctx context.Context, cmds []WatCommand, root string, e corpus.Entry) (CommandLogGroup, error) {
+	scratchRoot, cleanup, err := copyWorkspace(root)
+	if err != nil {
+		return CommandLogGroup{}, err
+	}
+	defer cleanup()
+
+	absPath := filepath.Join(scratchRoot, e.RelPath)
+	if err := ioutil.WriteFile(absPath, e.Contents, permFile); err != nil {
+		return CommandLogGroup{}, err
+	}
+
+	return runCmds(ctx, cmds, scratchRoot, LogContext{
+		StartTime:   time.Now(),
+		Source:      LogSourceCorpus,
+		RecentEdits: []string{e.RelPath},
+	}, nil, nil)
+}
@@ -0,0 +1,102 @@
+package wat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/windmilleng/wat/minimize"
+)
+
+// minimizeAttemptTimeout bounds how long a single candidate may run during
+// minimization, so a candidate that hangs (rather than failing outright)
+// can't eat the whole --minimize-time budget by itself.
+const minimizeAttemptTimeout = 5 * time.Second
+
+// minimizeMaxIters caps how many candidates minimizeFailure will try,
+// independent of --minimize-time, so a pathologically slow-to-fail command
+// can't spin forever even with a generous time budget.
+const minimizeMaxIters = 200
+
+// firstFailingLog returns the index of the first unsuccessful command in g,
+// if any.
+func firstFailingLog(g CommandLogGroup) (int, bool) {
+	for i, l := range g.Logs {
+		if !l.Success {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// minimizeFailure shrinks newContents down to a smaller edit that still
+// makes failingCmd fail the same way it failed against newContents, spending
+// at most budget wall-clock time. It rewrites absPath repeatedly as part of
+// the search; callers are responsible for restoring whatever file state they
+// need once it returns. A budget <= 0 disables minimization entirely.
+//
+// prefixCmds are the commands that ran before failingCmd in the configured
+// sequence (cmds[:idx] in fuzzAndRun's loop), and are re-run ahead of
+// failingCmd for every candidate. Some WatCommand sequences chain steps with
+// side effects a later command depends on (e.g. a build before a test), so
+// judging failingCmd against whatever state the original, unminimized
+// mutation happened to leave behind could produce a false verdict for a
+// candidate that hasn't gone through the same steps.
+//
+// It returns the minimized contents and how many bytes smaller they are than
+// newContents; a zero byte count means minimization didn't shrink anything.
+func minimizeFailure(ctx context.Context, root, absPath string, mode os.FileMode, oldContents, newContents []byte, prefixCmds []WatCommand, failingCmd WatCommand, budget time.Duration) ([]byte, int) {
+	if budget <= 0 {
+		return newContents, 0
+	}
+
+	run := func(ctx context.Context, contents []byte) (minimize.RunResult, error) {
+		if err := ioutil.WriteFile(absPath, contents, mode); err != nil {
+			return minimize.RunResult{}, err
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, minimizeAttemptTimeout)
+		defer cancel()
+
+		for _, prefixCmd := range prefixCmds {
+			l, err := runCmdAndLog(attemptCtx, root, prefixCmd, ioutil.Discard, ioutil.Discard)
+			if err != nil {
+				if err == context.DeadlineExceeded || err == context.Canceled {
+					return minimize.RunResult{}, nil
+				}
+				return minimize.RunResult{}, err
+			}
+			if !l.Success {
+				// A prefix command that passed during the original run no
+				// longer does against this candidate, so failingCmd's
+				// result below isn't comparable to the original failure;
+				// treat this candidate as not reproducing it.
+				return minimize.RunResult{}, nil
+			}
+		}
+
+		var stderr bytes.Buffer
+		l, err := runCmdAndLog(attemptCtx, root, failingCmd, ioutil.Discard, &stderr)
+		if err != nil {
+			if err == context.DeadlineExceeded || err == context.Canceled {
+				// Treat a candidate that merely took too long as "didn't
+				// reproduce the failure", rather than aborting the whole
+				// search over it.
+				return minimize.RunResult{}, nil
+			}
+			return minimize.RunResult{}, err
+		}
+
+		return minimize.RunResult{Failed: !l.Success, Signature: stderr.String()}, nil
+	}
+
+	result, err := minimize.Minimize(ctx, oldContents, newContents, run, budget, minimizeMaxIters)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "minimize: %v\n", err)
+		return newContents, 0
+	}
+	return result.Contents, result.MinimizedBytes
+}
@@ -0,0 +1,40 @@
+package wat
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFirstFailingLog(t *testing.T) {
+	g := CommandLogGroup{Logs: []CommandLog{
+		{Command: "go test ./a", Success: true},
+		{Command: "go test ./b", Success: false},
+		{Command: "go test ./c", Success: false},
+	}}
+
+	idx, ok := firstFailingLog(g)
+	if !ok {
+		t.Fatal("firstFailingLog: got ok = false, want true")
+	}
+	if idx != 1 {
+		t.Fatalf("firstFailingLog: got index %d, want 1", idx)
+	}
+
+	allPassed := CommandLogGroup{Logs: []CommandLog{{Success: true}}}
+	if _, ok := firstFailingLog(allPassed); ok {
+		t.Fatal("firstFailingLog: got ok = true for an all-passing group, want false")
+	}
+}
+
+func TestMinimizeFailureDisabledByZeroBudget(t *testing.T) {
+	newContents := []byte("some mutated contents")
+	contents, minimizedBytes := minimizeFailure(context.Background(), "/root", "/root/f.go", 0644,
+		[]byte("old"), newContents, nil, WatCommand{Command: "go test ./..."}, 0)
+
+	if string(contents) != string(newContents) {
+		t.Fatalf("contents = %q, want newContents unchanged (%q)", contents, newContents)
+	}
+	if minimizedBytes != 0 {
+		t.Fatalf("minimizedBytes = %d, want 0 when minimization is disabled", minimizedBytes)
+	}
+}
@@ -0,0 +1,40 @@
+package wat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTrainLimit(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    trainLimit
+		wantErr bool
+	}{
+		{in: "", want: trainLimit{}},
+		{in: "30s", want: trainLimit{duration: 30 * time.Second}},
+		{in: "5m", want: trainLimit{duration: 5 * time.Minute}},
+		{in: "500x", want: trainLimit{count: 500}},
+		{in: "0x", want: trainLimit{count: 0}},
+		{in: "not-a-duration", wantErr: true},
+		{in: "x", wantErr: true},
+		{in: "-5x", wantErr: true},
+	}
+
+	for _, c := range cases {
+		got, err := parseTrainLimit(c.in)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parseTrainLimit(%q): got nil error, want one", c.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTrainLimit(%q): unexpected error: %v", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("parseTrainLimit(%q) = %+v, want %+v", c.in, got, c.want)
+		}
+	}
+}
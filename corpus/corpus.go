@@ -0,0 +1,120 @@
+// Package corpus stores fuzzed file contents that turned out to be
+// interesting (they failed, or found new coverage) so a later training run
+// can replay them without having to rediscover them by chance.
+package corpus
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const header = "# wat corpus v1"
+
+var separator = []byte("\n\n")
+
+// Entry is one stored fuzz input.
+type Entry struct {
+	// The workspace-relative path of the file this mutation applies to.
+	RelPath string
+
+	// The SHA-256 (hex) of Contents. Doubles as the entry's filename.
+	SHA string
+
+	// The SHA-256 (hex) of the contents this mutation was derived from.
+	Parent string
+
+	Contents []byte
+}
+
+// Save writes contents to the corpus under corpusRoot, keyed by its
+// SHA-256 hash, recording parentContents' hash as its lineage. It's safe to
+// call Save with the same contents more than once; a later write replaces
+// the earlier file, including its recorded parent.
+func Save(corpusRoot, relPath string, contents, parentContents []byte) (Entry, error) {
+	sum := sha256.Sum256(contents)
+	parentSum := sha256.Sum256(parentContents)
+	entry := Entry{
+		RelPath:  relPath,
+		SHA:      hex.EncodeToString(sum[:]),
+		Parent:   hex.EncodeToString(parentSum[:]),
+		Contents: contents,
+	}
+
+	dir := filepath.Join(corpusRoot, relPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Entry{}, err
+	}
+
+	return entry, ioutil.WriteFile(filepath.Join(dir, entry.SHA), encode(entry), 0644)
+}
+
+// List walks corpusRoot and returns every stored Entry. A corpusRoot that
+// doesn't exist yet (no training run has found anything interesting) is not
+// an error; it just yields no entries.
+func List(corpusRoot string) ([]Entry, error) {
+	var entries []Entry
+	err := filepath.Walk(corpusRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		entry, err := decode(data)
+		if err != nil {
+			return fmt.Errorf("corpus: %s: %v", path, err)
+		}
+		entry.SHA = filepath.Base(path)
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func encode(e Entry) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n", header)
+	fmt.Fprintf(&b, "# parent: %s\n", e.Parent)
+	fmt.Fprintf(&b, "# source-file: %s", e.RelPath)
+	b.Write(separator)
+	b.Write(e.Contents)
+	return b.Bytes()
+}
+
+func decode(data []byte) (Entry, error) {
+	idx := bytes.Index(data, separator)
+	if idx < 0 {
+		return Entry{}, fmt.Errorf("missing header/body separator")
+	}
+
+	var e Entry
+	e.Contents = data[idx+len(separator):]
+
+	for _, line := range strings.Split(string(data[:idx]), "\n") {
+		switch {
+		case strings.HasPrefix(line, "# parent: "):
+			e.Parent = strings.TrimPrefix(line, "# parent: ")
+		case strings.HasPrefix(line, "# source-file: "):
+			e.RelPath = strings.TrimPrefix(line, "# source-file: ")
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,56 @@
+package corpus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSaveAndList(t *testing.T) {
+	root, err := ioutil.TempDir("", "corpus-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	contents := []byte("package foo\n\nfunc Bar() bool { return true }\n")
+	parent := []byte("package foo\n\nfunc Bar() bool { return false }\n")
+
+	saved, err := Save(root, "pkg/foo.go", contents, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := List(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+
+	got := entries[0]
+	if got.SHA != saved.SHA {
+		t.Errorf("SHA = %q, want %q", got.SHA, saved.SHA)
+	}
+	if got.RelPath != "pkg/foo.go" {
+		t.Errorf("RelPath = %q, want %q", got.RelPath, "pkg/foo.go")
+	}
+	if got.Parent != saved.Parent {
+		t.Errorf("Parent = %q, want %q", got.Parent, saved.Parent)
+	}
+	if !bytes.Equal(got.Contents, contents) {
+		t.Errorf("Contents = %q, want %q", got.Contents, contents)
+	}
+}
+
+func TestListOnMissingCorpus(t *testing.T) {
+	entries, err := List("/nonexistent/wat-corpus-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("got %d entries, want 0", len(entries))
+	}
+}
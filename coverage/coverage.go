@@ -0,0 +1,159 @@
+// Package coverage measures Go test coverage for a single command
+// invocation and tracks it as a compact bitmap, so a fuzzer can tell
+// whether a mutation exercised any code it hadn't seen before.
+package coverage
+
+import (
+	"hash/fnv"
+	"io/ioutil"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// bitmapBits is the size of a Bitmap, in bits. Blocks are indexed by a hash
+// of their file+line+col span mod bitmapBits, so two distinct blocks can in
+// principle collide into the same bit; that's an acceptable trade-off here
+// since the bitmap is only used to prioritize fuzzing, not to report exact
+// coverage.
+const bitmapBits = 1 << 16
+const bitmapBytes = bitmapBits / 8
+
+// Bitmap is a fixed-size set of covered basic blocks, one bit per block.
+type Bitmap []byte
+
+// NewBitmap returns an all-zero Bitmap.
+func NewBitmap() Bitmap {
+	return make(Bitmap, bitmapBytes)
+}
+
+func (b Bitmap) set(block string) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(block))
+	idx := h.Sum64() % bitmapBits
+	b[idx/8] |= 1 << (idx % 8)
+}
+
+// IsGoTest reports whether command looks like a single, self-contained `go
+// test` invocation, i.e. one we know how to safely instrument with
+// -coverprofile by appending the flag to the end of the string. A command
+// that chains multiple programs together (with &&, a pipe, a subshell, ...)
+// isn't safe to instrument this way: the appended flag could land on the
+// wrong program, or be silently swallowed, so those are rejected even
+// though they may contain "go test" somewhere in them.
+func IsGoTest(command string) bool {
+	return strings.Contains(command, "go test") && !hasShellChaining(command)
+}
+
+// hasShellChaining reports whether command combines more than one program
+// invocation via a shell operator.
+func hasShellChaining(command string) bool {
+	for _, op := range []string{"&&", "||", "|", ";", "`", "$("} {
+		if strings.Contains(command, op) {
+			return true
+		}
+	}
+	return false
+}
+
+// Instrument rewrites command (which must satisfy IsGoTest) to also write a
+// coverage profile, so the caller's own run of that command can be profiled
+// without running it a second time. ok is false for a command that isn't a
+// single self-contained `go test` invocation, in which case command is
+// returned unchanged and profilePath is empty. The caller must call the
+// returned cleanup once it's done reading the profile.
+func Instrument(command string) (instrumented, profilePath string, cleanup func(), ok bool) {
+	if !IsGoTest(command) {
+		return command, "", func() {}, false
+	}
+
+	f, err := ioutil.TempFile("", "wat-coverprofile-")
+	if err != nil {
+		return command, "", func() {}, false
+	}
+	profilePath = f.Name()
+	f.Close()
+
+	return command + " -coverprofile=" + profilePath, profilePath, func() { os.Remove(profilePath) }, true
+}
+
+// ParseProfile reads a Go coverprofile (as written by `go test
+// -coverprofile`) and sets a bit for every block with a non-zero execution
+// count. A missing profile (e.g. the command failed before writing one) is
+// not an error; it just yields a nil Bitmap.
+func ParseProfile(path string) (Bitmap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	bitmap := NewBitmap()
+	lines := strings.Split(string(data), "\n")
+	if len(lines) > 0 {
+		lines = lines[1:] // skip the "mode: ..." header line
+	}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		// Each line is "file:startLine.startCol,endLine.endCol numStmt count".
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+
+		count, err := strconv.Atoi(fields[2])
+		if err != nil || count == 0 {
+			continue
+		}
+
+		bitmap.set(fields[0])
+	}
+	return bitmap, nil
+}
+
+// Tracker is a goroutine-safe running union of bitmaps observed across many
+// fuzz iterations. Merging a new Bitmap into it reports how many
+// previously-unseen blocks it covers, which is what lets the training loop
+// tell a genuinely interesting mutation from a redundant one.
+type Tracker struct {
+	mu    sync.Mutex
+	union Bitmap
+}
+
+// NewTracker returns an empty Tracker.
+func NewTracker() *Tracker {
+	return &Tracker{union: NewBitmap()}
+}
+
+// Merge ORs bitmap into the running union and returns the number of bits
+// that were newly set by doing so.
+func (t *Tracker) Merge(bitmap Bitmap) int {
+	if bitmap == nil {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delta := 0
+	for i, b := range bitmap {
+		if i >= len(t.union) {
+			break
+		}
+		newBits := b &^ t.union[i]
+		if newBits == 0 {
+			continue
+		}
+		delta += bits.OnesCount8(newBits)
+		t.union[i] |= newBits
+	}
+	return delta
+}
@@ -0,0 +1,115 @@
+package coverage
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrackerMergeReportsOnlyNewBits(t *testing.T) {
+	tracker := NewTracker()
+
+	a := NewBitmap()
+	a.set("file.go:1.1,2.2")
+	a.set("file.go:3.1,4.2")
+
+	delta := tracker.Merge(a)
+	if delta != 2 {
+		t.Fatalf("first merge: got delta %d, want 2", delta)
+	}
+
+	// Merging the same bitmap again should report no new bits.
+	delta = tracker.Merge(a)
+	if delta != 0 {
+		t.Fatalf("repeat merge: got delta %d, want 0", delta)
+	}
+
+	b := NewBitmap()
+	b.set("file.go:1.1,2.2") // already seen
+	b.set("file.go:5.1,6.2") // new
+
+	delta = tracker.Merge(b)
+	if delta != 1 {
+		t.Fatalf("partial overlap merge: got delta %d, want 1", delta)
+	}
+}
+
+func TestIsGoTest(t *testing.T) {
+	cases := map[string]bool{
+		"go test ./...":                true,
+		"go test -v ./pkg/...":         true,
+		"npm test":                     false,
+		"make test":                    false,
+		"echo hi && go test ./...":     false, // chained: flag could land on the wrong program
+		"go test ./... || echo failed": false,
+		"go test ./... | tee log.txt":  false,
+		"go test ./...; echo done":     false,
+		"go test $(echo ./...)":        false,
+	}
+	for cmd, want := range cases {
+		if got := IsGoTest(cmd); got != want {
+			t.Errorf("IsGoTest(%q) = %v, want %v", cmd, got, want)
+		}
+	}
+}
+
+// TestInstrumentRoundTripsWithParseProfile builds a tiny real Go package,
+// instruments `go test ./...` against it, runs the instrumented command for
+// real, and confirms ParseProfile recovers coverage for the line it
+// exercised. This is the only test that actually exercises Instrument
+// end-to-end rather than just IsGoTest's string matching.
+func TestInstrumentRoundTripsWithParseProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wat-coverage-instrument-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pkgDir := filepath.Join(dir, "pkg")
+	if err := os.Mkdir(pkgDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module instrumenttest\n\ngo 1.21\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(pkgDir, "add.go"), []byte("package pkg\n\nfunc Add(a, b int) int {\n\treturn a + b\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(pkgDir, "add_test.go"), []byte("package pkg\n\nimport \"testing\"\n\nfunc TestAdd(t *testing.T) {\n\tif Add(2, 3) != 5 {\n\t\tt.Fatal(\"bad add\")\n\t}\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	instrumented, profilePath, cleanup, ok := Instrument("go test ./...")
+	if !ok {
+		t.Fatal("Instrument(\"go test ./...\"): got ok = false, want true")
+	}
+	defer cleanup()
+
+	cmd := exec.Command("sh", "-c", instrumented)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("instrumented command failed: %v\n%s", err, out)
+	}
+
+	bitmap, err := ParseProfile(profilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bitmap == nil {
+		t.Fatal("ParseProfile returned a nil Bitmap for a passing, coverage-producing test run")
+	}
+
+	empty := NewBitmap()
+	allZero := true
+	for i := range bitmap {
+		if bitmap[i] != empty[i] {
+			allZero = false
+			break
+		}
+	}
+	if allZero {
+		t.Fatal("ParseProfile returned an all-zero Bitmap; Add's line should have been covered")
+	}
+}
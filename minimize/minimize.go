@@ -0,0 +1,150 @@
+// Package minimize shrinks a failing fuzz mutation down to a smaller one
+// that still reproduces the same failure, so the input recorded for a bug
+// is something a human can actually read.
+package minimize
+
+import (
+	"context"
+	"regexp"
+	"time"
+)
+
+// RunResult is the outcome of trying one candidate set of file contents.
+type RunResult struct {
+	Failed bool
+
+	// Identifies which failure this was, e.g. an exit-status/stderr
+	// combination. Minimize only accepts a candidate that reproduces the
+	// same (normalized, see normalizeSignature) Signature as the original
+	// failure, so it can't "fix" the bug by wandering into a different one.
+	// Callers don't need to normalize this themselves.
+	Signature string
+}
+
+// RunFunc applies contents and reports whether the failure still
+// reproduces.
+type RunFunc func(ctx context.Context, contents []byte) (RunResult, error)
+
+// Result is what Minimize found.
+type Result struct {
+	Contents []byte
+
+	// How many fewer bytes Contents is than the original fuzzed input.
+	MinimizedBytes int
+}
+
+// Minimize searches for a smaller edit that reproduces the same failure
+// signature as newContents did against oldContents, spending at most budget
+// wall-clock time and maxIters calls to run.
+//
+// It's a simplified delta-debugging (ddmin) search over the region where
+// oldContents and newContents actually differ: repeatedly try deleting
+// chunks of that region, halving the chunk size whenever no chunk of the
+// current size can be removed without losing the failure. A final pass
+// collapses whatever's left down to a single byte, which is what turns a
+// multi-byte insert into the smallest possible reproduction.
+func Minimize(ctx context.Context, oldContents, newContents []byte, run RunFunc, budget time.Duration, maxIters int) (Result, error) {
+	deadline := time.Now().Add(budget)
+
+	baseline, err := run(ctx, newContents)
+	if err != nil {
+		return Result{Contents: newContents}, err
+	}
+	if !baseline.Failed {
+		// Can't even reproduce the failure once more (e.g. it was flaky),
+		// so there's nothing safe to minimize.
+		return Result{Contents: newContents}, nil
+	}
+	signature := normalizeSignature(baseline.Signature)
+
+	windowStart, suffixLen := commonAffixLens(oldContents, newContents)
+	windowEnd := len(newContents) - suffixLen
+
+	current := append([]byte(nil), newContents...)
+	iters := 0
+	expired := func() bool {
+		return iters >= maxIters || time.Now().After(deadline)
+	}
+
+	for chunkSize := windowEnd - windowStart; chunkSize >= 1 && !expired(); chunkSize /= 2 {
+		improved := false
+		for start := windowStart; start < windowEnd && !expired(); start += chunkSize {
+			end := start + chunkSize
+			if end > windowEnd {
+				end = windowEnd
+			}
+
+			candidate := make([]byte, 0, len(current)-(end-start))
+			candidate = append(candidate, current[:start]...)
+			candidate = append(candidate, current[end:]...)
+
+			iters++
+			result, err := run(ctx, candidate)
+			if err != nil {
+				return Result{Contents: current, MinimizedBytes: len(newContents) - len(current)}, err
+			}
+			if result.Failed && normalizeSignature(result.Signature) == signature {
+				current = candidate
+				windowEnd -= end - start
+				improved = true
+				break
+			}
+		}
+		if improved {
+			// Re-try the same chunk size from the new, shorter window
+			// before halving.
+			chunkSize *= 2
+		}
+	}
+
+	if windowEnd-windowStart > 1 && !expired() {
+		candidate := make([]byte, 0, windowStart+1+len(current)-windowEnd)
+		candidate = append(candidate, current[:windowStart]...)
+		candidate = append(candidate, current[windowStart])
+		candidate = append(candidate, current[windowEnd:]...)
+
+		iters++
+		if result, err := run(ctx, candidate); err == nil && result.Failed && normalizeSignature(result.Signature) == signature {
+			current = candidate
+		}
+	}
+
+	return Result{
+		Contents:       current,
+		MinimizedBytes: len(newContents) - len(current),
+	}, nil
+}
+
+// positionToken matches a ":line:col" span as reported by the Go compiler
+// and most other toolchains' error messages, e.g. the ":12:5" in
+// "foo.go:12:5: syntax error", which normalizes to "foo.go: syntax error".
+var positionToken = regexp.MustCompile(`:\d+:\d+`)
+
+// normalizeSignature strips source positions out of a raw Signature before
+// comparing candidates. Every ddmin candidate edits bytes in or around the
+// failing region, so the compiler/interpreter's reported line:col shifts on
+// almost every candidate even when it's the exact same failure recurring;
+// comparing raw, unnormalized signatures would make them look different and
+// silently disable shrinking.
+func normalizeSignature(s string) string {
+	return positionToken.ReplaceAllString(s, "")
+}
+
+// commonAffixLens returns the lengths of the common prefix and the
+// (non-overlapping) common suffix of a and b.
+func commonAffixLens(a, b []byte) (prefixLen, suffixLen int) {
+	limit := len(a)
+	if len(b) < limit {
+		limit = len(b)
+	}
+
+	for prefixLen < limit && a[prefixLen] == b[prefixLen] {
+		prefixLen++
+	}
+
+	limit -= prefixLen
+	for suffixLen < limit && a[len(a)-1-suffixLen] == b[len(b)-1-suffixLen] {
+		suffixLen++
+	}
+	return prefixLen, suffixLen
+}
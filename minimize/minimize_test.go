@@ -0,0 +1,98 @@
+package minimize
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// needle is the only byte sequence that makes our fake run fail.
+const needle = "BOOM"
+
+func fakeRun(t *testing.T) RunFunc {
+	return func(ctx context.Context, contents []byte) (RunResult, error) {
+		if bytes.Contains(contents, []byte(needle)) {
+			return RunResult{Failed: true, Signature: "boom"}, nil
+		}
+		return RunResult{Failed: false}, nil
+	}
+}
+
+// shiftingSignatureRun mimics a real compiler error: it fails whenever
+// contents contains needle, but its Signature embeds the line:col where
+// needle starts, the way `go build`'s error output would. Since ddmin edits
+// bytes around the failing region on nearly every candidate, that line:col
+// shifts from candidate to candidate even though it's the same failure
+// recurring - this is what normalizeSignature has to see through.
+func shiftingSignatureRun(t *testing.T) RunFunc {
+	return func(ctx context.Context, contents []byte) (RunResult, error) {
+		idx := bytes.Index(contents, []byte(needle))
+		if idx < 0 {
+			return RunResult{Failed: false}, nil
+		}
+		line := bytes.Count(contents[:idx], []byte("\n")) + 1
+		col := idx - bytes.LastIndexByte(contents[:idx], '\n')
+		return RunResult{
+			Failed:    true,
+			Signature: fmt.Sprintf("file.go:%d:%d: unexpected %s", line, col, needle),
+		}, nil
+	}
+}
+
+func TestMinimizeShrinksToJustTheFailingBytes(t *testing.T) {
+	old := []byte("package foo\n\nfunc Bar() {}\n")
+	mutated := []byte("package foo\n\nfunc Bar() { " + needle + " lots of irrelevant padding here }\n")
+
+	result, err := Minimize(context.Background(), old, mutated, fakeRun(t), time.Second, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(result.Contents, []byte(needle)) {
+		t.Fatalf("minimized contents lost the failing bytes: %q", result.Contents)
+	}
+	if len(result.Contents) >= len(mutated) {
+		t.Fatalf("minimize did not shrink the input: got %d bytes, started with %d", len(result.Contents), len(mutated))
+	}
+	if result.MinimizedBytes != len(mutated)-len(result.Contents) {
+		t.Fatalf("MinimizedBytes = %d, want %d", result.MinimizedBytes, len(mutated)-len(result.Contents))
+	}
+}
+
+func TestMinimizeShrinksDespiteShiftingLineNumbers(t *testing.T) {
+	old := []byte("package foo\n\nfunc Bar() {}\n")
+	mutated := []byte("package foo\n\nfunc Bar() { " + needle + " lots of irrelevant padding here }\n")
+
+	result, err := Minimize(context.Background(), old, mutated, shiftingSignatureRun(t), time.Second, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(result.Contents, []byte(needle)) {
+		t.Fatalf("minimized contents lost the failing bytes: %q", result.Contents)
+	}
+	if len(result.Contents) >= len(mutated) {
+		t.Fatalf("minimize did not shrink the input despite a shifting line:col signature: got %d bytes, started with %d", len(result.Contents), len(mutated))
+	}
+	if result.MinimizedBytes != len(mutated)-len(result.Contents) {
+		t.Fatalf("MinimizedBytes = %d, want %d", result.MinimizedBytes, len(mutated)-len(result.Contents))
+	}
+}
+
+func TestMinimizeNoOpWhenNotReproducible(t *testing.T) {
+	old := []byte("package foo\n")
+	mutated := []byte("package foo\n// mutated, but not the boom case\n")
+
+	result, err := Minimize(context.Background(), old, mutated, fakeRun(t), time.Second, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result.Contents, mutated) {
+		t.Fatalf("expected no shrinking when the failure doesn't reproduce, got %q", result.Contents)
+	}
+	if result.MinimizedBytes != 0 {
+		t.Fatalf("MinimizedBytes = %d, want 0", result.MinimizedBytes)
+	}
+}
@@ -0,0 +1,239 @@
+// Package mutator implements a byte-slice mutation engine for fuzzing
+// source files, modeled on the mutators used by Go's own dev.fuzz work
+// (src/internal/fuzz/mutators_byteslice.go). Unlike a single fixed
+// transformation, it applies a random menu of small, local edits, which is
+// what lets `wat train` exercise many different kinds of bugs instead of
+// just flipping `false` to `true`.
+package mutator
+
+import (
+	"strconv"
+)
+
+// interestingInts are boundary values that tend to shake out off-by-one and
+// overflow bugs when substituted for an integer literal in source.
+var interestingInts = []int64{
+	0, 1, -1,
+	127, 128, -128, // int8
+	32767, 32768, -32768, // int16
+	2147483647, 2147483648, -2147483648, // int32
+}
+
+// matchedPairs is a set of token pairs that are meaningful to swap in
+// source code: swapMatchedPair rewrites one randomly chosen occurrence of
+// either half to the other half.
+var matchedPairs = [][2]string{
+	{"true", "false"},
+	{"==", "!="},
+}
+
+// Mutator applies randomized, reproducible byte-level mutations to file
+// contents. It holds its own RNG state and a reusable scratch buffer, so a
+// single Mutator can be used to fuzz many files in a row without
+// reallocating on every call.
+type Mutator struct {
+	r       *pcg32
+	scratch []byte
+}
+
+// New returns a Mutator seeded with seed. The same seed always produces the
+// same sequence of mutations for the same inputs. `wat train`'s fuzz workers
+// seed their Mutators from the clock, so two training runs do not apply the
+// same mutations.
+func New(seed uint64) *Mutator {
+	return &Mutator{r: newPCG32(seed, 0)}
+}
+
+// Mutate returns a mutated copy of b. It applies 1..8 stacked mutation
+// operations, each chosen uniformly from the operation menu, and never
+// modifies b itself.
+func (m *Mutator) Mutate(b []byte) []byte {
+	m.scratch = append(m.scratch[:0], b...)
+
+	numOps := 1 + m.r.intn(8)
+	for i := 0; i < numOps; i++ {
+		op := mutations[m.r.intn(len(mutations))]
+		m.scratch = op(m.r, m.scratch)
+	}
+
+	out := make([]byte, len(m.scratch))
+	copy(out, m.scratch)
+	return out
+}
+
+// mutation is a single primitive edit. Each one takes the current contents
+// and returns the (possibly reallocated) mutated contents.
+type mutation func(r *pcg32, b []byte) []byte
+
+var mutations = []mutation{
+	insertByte,
+	deleteByteRange,
+	duplicateSubslice,
+	bitFlipByte,
+	swapTwoBytes,
+	adjustASCIIInt,
+	replaceASCIIIntWithInteresting,
+	swapMatchedPair,
+}
+
+func insertByte(r *pcg32, b []byte) []byte {
+	if len(b) == 0 {
+		return []byte{byte(r.intn(256))}
+	}
+	i := r.intn(len(b) + 1)
+	out := make([]byte, 0, len(b)+1)
+	out = append(out, b[:i]...)
+	out = append(out, byte(r.intn(256)))
+	out = append(out, b[i:]...)
+	return out
+}
+
+func deleteByteRange(r *pcg32, b []byte) []byte {
+	if len(b) == 0 {
+		return b
+	}
+	start := r.intn(len(b))
+	maxLen := len(b) - start
+	n := 1 + r.intn(maxLen)
+	out := make([]byte, 0, len(b)-n)
+	out = append(out, b[:start]...)
+	out = append(out, b[start+n:]...)
+	return out
+}
+
+func duplicateSubslice(r *pcg32, b []byte) []byte {
+	if len(b) == 0 {
+		return b
+	}
+	start := r.intn(len(b))
+	maxLen := len(b) - start
+	n := 1 + r.intn(maxLen)
+	sub := b[start : start+n]
+	insertAt := r.intn(len(b) + 1)
+	out := make([]byte, 0, len(b)+n)
+	out = append(out, b[:insertAt]...)
+	out = append(out, sub...)
+	out = append(out, b[insertAt:]...)
+	return out
+}
+
+func bitFlipByte(r *pcg32, b []byte) []byte {
+	if len(b) == 0 {
+		return b
+	}
+	i := r.intn(len(b))
+	bit := uint(r.intn(8))
+	b[i] ^= 1 << bit
+	return b
+}
+
+func swapTwoBytes(r *pcg32, b []byte) []byte {
+	if len(b) < 2 {
+		return b
+	}
+	i := r.intn(len(b))
+	j := r.intn(len(b))
+	b[i], b[j] = b[j], b[i]
+	return b
+}
+
+// asciiIntRun is a maximal run of ASCII digits (with an optional leading
+// '-') found in b.
+type asciiIntRun struct {
+	start, end int // b[start:end] is the digit run, end exclusive
+}
+
+func findASCIIIntRuns(b []byte) []asciiIntRun {
+	var runs []asciiIntRun
+	i := 0
+	for i < len(b) {
+		start := i
+		if b[i] == '-' && i+1 < len(b) && isDigit(b[i+1]) {
+			i++
+		}
+		digitsStart := i
+		for i < len(b) && isDigit(b[i]) {
+			i++
+		}
+		if i > digitsStart {
+			runs = append(runs, asciiIntRun{start: start, end: i})
+		} else {
+			i++
+		}
+	}
+	return runs
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func adjustASCIIInt(r *pcg32, b []byte) []byte {
+	runs := findASCIIIntRuns(b)
+	if len(runs) == 0 {
+		return b
+	}
+	run := runs[r.intn(len(runs))]
+	n, err := strconv.ParseInt(string(b[run.start:run.end]), 10, 64)
+	if err != nil {
+		return b
+	}
+
+	delta := int64(1 + r.intn(10))
+	if r.bool() {
+		delta = -delta
+	}
+	return replaceIntRun(b, run, n+delta)
+}
+
+func replaceASCIIIntWithInteresting(r *pcg32, b []byte) []byte {
+	runs := findASCIIIntRuns(b)
+	if len(runs) == 0 {
+		return b
+	}
+	run := runs[r.intn(len(runs))]
+	n := interestingInts[r.intn(len(interestingInts))]
+	return replaceIntRun(b, run, n)
+}
+
+func replaceIntRun(b []byte, run asciiIntRun, n int64) []byte {
+	replacement := []byte(strconv.FormatInt(n, 10))
+	out := make([]byte, 0, len(b)-(run.end-run.start)+len(replacement))
+	out = append(out, b[:run.start]...)
+	out = append(out, replacement...)
+	out = append(out, b[run.end:]...)
+	return out
+}
+
+func swapMatchedPair(r *pcg32, b []byte) []byte {
+	pair := matchedPairs[r.intn(len(matchedPairs))]
+	lhs, rhs := []byte(pair[0]), []byte(pair[1])
+
+	var occurrences [][2]int // [offset, which] where which==0 means lhs, 1 means rhs
+	findAll(b, lhs, 0, &occurrences)
+	findAll(b, rhs, 1, &occurrences)
+	if len(occurrences) == 0 {
+		return b
+	}
+
+	occ := occurrences[r.intn(len(occurrences))]
+	offset, which := occ[0], occ[1]
+	from, to := lhs, rhs
+	if which == 1 {
+		from, to = rhs, lhs
+	}
+
+	out := make([]byte, 0, len(b)-len(from)+len(to))
+	out = append(out, b[:offset]...)
+	out = append(out, to...)
+	out = append(out, b[offset+len(from):]...)
+	return out
+}
+
+func findAll(b, sep []byte, which int, occurrences *[][2]int) {
+	for i := 0; i+len(sep) <= len(b); i++ {
+		if string(b[i:i+len(sep)]) == string(sep) {
+			*occurrences = append(*occurrences, [2]int{i, which})
+		}
+	}
+}
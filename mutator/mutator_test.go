@@ -0,0 +1,36 @@
+package mutator
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMutateIsDeterministic(t *testing.T) {
+	input := []byte("if shouldRetry == true { count := 0 }")
+
+	out1 := New(42).Mutate(input)
+	out2 := New(42).Mutate(input)
+
+	if !bytes.Equal(out1, out2) {
+		t.Fatalf("mutations with the same seed should be identical: %q != %q", out1, out2)
+	}
+	if bytes.Equal(out1, input) {
+		t.Fatalf("Mutate should change the input")
+	}
+}
+
+func TestMutateDoesNotModifyInput(t *testing.T) {
+	input := []byte("count := 0")
+	orig := append([]byte(nil), input...)
+
+	New(1).Mutate(input)
+
+	if !bytes.Equal(input, orig) {
+		t.Fatalf("Mutate must not modify its argument in place: got %q, want %q", input, orig)
+	}
+}
+
+func TestMutateHandlesEmptyInput(t *testing.T) {
+	out := New(7).Mutate(nil)
+	_ = out // just must not panic
+}
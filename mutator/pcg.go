@@ -0,0 +1,59 @@
+package mutator
+
+// A minimal PCG32 (permuted congruential generator), the same family of RNG
+// used by Go's own dev.fuzz corpus mutator. We don't need cryptographic
+// randomness here, just a small, fast, seedable generator with a known-good
+// output distribution so that a fixed seed always produces the same
+// sequence of mutations.
+type pcg32 struct {
+	state uint64
+	inc   uint64
+}
+
+const (
+	pcgMultiplier uint64 = 6364136223846793005
+	pcgIncrement  uint64 = 1442695040888963407
+)
+
+func newPCG32(seed, seq uint64) *pcg32 {
+	p := &pcg32{}
+	p.seed(seed, seq)
+	return p
+}
+
+func (p *pcg32) seed(seed, seq uint64) {
+	p.state = 0
+	p.inc = (seq << 1) | 1
+	p.next()
+	p.state += seed
+	p.next()
+}
+
+func (p *pcg32) next() uint32 {
+	oldState := p.state
+	p.state = oldState*pcgMultiplier + p.inc
+	xorshifted := uint32(((oldState >> 18) ^ oldState) >> 27)
+	rot := uint32(oldState >> 59)
+	return (xorshifted >> rot) | (xorshifted << ((-rot) & 31))
+}
+
+// uint32n returns a random value in [0, n), using Lemire's multiply-shift
+// reduction without its rejection step. The result is exactly uniform when
+// n is a power of two and very slightly biased otherwise.
+func (p *pcg32) uint32n(n uint32) uint32 {
+	if n == 0 {
+		return 0
+	}
+	prod := uint64(p.next()) * uint64(n)
+	return uint32(prod >> 32)
+}
+
+// intn returns a random value in [0, n); see uint32n.
+func (p *pcg32) intn(n int) int {
+	return int(p.uint32n(uint32(n)))
+}
+
+// bool returns a uniform random boolean.
+func (p *pcg32) bool() bool {
+	return p.next()&1 == 1
+}